@@ -0,0 +1,62 @@
+package sqldb
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// Backend is implemented by each supported SQL backend (Postgres, SQLite,
+// ...). Registering a Backend lets Open pick the right driver for a DSN
+// based on its URL scheme, so new backends (MySQL, CockroachDB, an
+// in-memory test backend, ...) can be added without touching call sites
+// that just want "the store for this DSN".
+type Backend interface {
+	// Open creates a new store using the given DSN.
+	Open(dsn string) (*BaseDB, error)
+
+	// Name returns the human-readable name of the backend, e.g.
+	// "postgres" or "sqlite".
+	Name() string
+
+	// MigrationReplacements returns the set of SQL token substitutions
+	// (e.g. "BLOB" -> "BYTEA") that must be applied to the shared sqlc
+	// migration files before they're run against this backend.
+	MigrationReplacements() map[string]string
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]Backend)
+)
+
+// RegisterBackend makes a Backend available under the given DSN URL
+// scheme(s). It's expected to be called from the init() function of the
+// file that implements the backend.
+func RegisterBackend(backend Backend, schemes ...string) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	for _, scheme := range schemes {
+		backends[scheme] = backend
+	}
+}
+
+// Open opens a new store, picking the backend driver from the DSN's URL
+// scheme (e.g. "postgres://", "pgx://", "sqlite://").
+func Open(dsn string) (*BaseDB, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DSN: %w", err)
+	}
+
+	backendsMu.RLock()
+	backend, ok := backends[u.Scheme]
+	backendsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported SQL backend scheme %q", u.Scheme)
+	}
+
+	return backend.Open(dsn)
+}
@@ -0,0 +1,234 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// migrationVersionRE matches the leading numeric version of a golang-migrate
+// source file, e.g. "000012_add_foo.up.sql" -> "000012".
+var migrationVersionRE = regexp.MustCompile(`^(\d+)_`)
+
+// pgUndefinedTableSQLState is the Postgres SQLSTATE returned when a query
+// references a table that doesn't exist, e.g. a migrations table that
+// hasn't been created yet.
+const pgUndefinedTableSQLState = "42P01"
+
+// isUndefinedTableError returns true if err is a Postgres error indicating
+// that the referenced table does not exist.
+func isUndefinedTableError(err error) bool {
+	var pgErr *pgconn.PgError
+
+	return errors.As(err, &pgErr) && pgErr.Code == pgUndefinedTableSQLState
+}
+
+// latestSchemaVersion walks the migration source directory and returns the
+// highest version number found among its files. This is the schema version
+// the current binary knows how to migrate up to.
+func latestSchemaVersion(fsys fs.FS, dir string) (uint, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read migrations dir: %w", err)
+	}
+
+	var latest uint
+	for _, entry := range entries {
+		matches := migrationVersionRE.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(matches[1], 10, 32)
+		if err != nil {
+			continue
+		}
+
+		if uint(version) > latest {
+			latest = uint(version)
+		}
+	}
+
+	return latest, nil
+}
+
+// currentSchemaVersion reads the version and dirty flag recorded in the
+// migrations table (named by migrationsTable, e.g. "schema_migrations"). A
+// brand new database without that table yet is reported as version 0, not
+// dirty.
+func currentSchemaVersion(ctx context.Context, db *sql.DB,
+	migrationsTable string) (uint, bool, error) {
+
+	var (
+		version int64
+		dirty   bool
+	)
+
+	query := fmt.Sprintf(
+		`SELECT version, dirty FROM "%s" LIMIT 1`, migrationsTable,
+	)
+	row := db.QueryRowContext(ctx, query)
+
+	switch err := row.Scan(&version, &dirty); {
+	case errors.Is(err, sql.ErrNoRows):
+		return 0, false, nil
+
+	// The table itself doesn't exist yet on a database that has never
+	// been migrated.
+	case err != nil && isUndefinedTableError(err):
+		return 0, false, nil
+
+	case err != nil:
+		return 0, false, err
+	}
+
+	return uint(version), dirty, nil
+}
+
+// databaseHasTables returns true if the public schema already contains any
+// base tables other than the migrations table itself, so that a database
+// whose only content is an empty, freshly-created migrations table (e.g.
+// left behind by a migration that failed before recording a version) isn't
+// mistaken for a non-empty, unversioned database.
+func databaseHasTables(ctx context.Context, db *sql.DB,
+	migrationsTable string) (bool, error) {
+
+	var count int
+	err := db.QueryRowContext(ctx, `
+		SELECT count(*) FROM information_schema.tables
+		WHERE table_schema = 'public'
+		  AND table_type = 'BASE TABLE'
+		  AND table_name <> $1
+	`, migrationsTable).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// checkSchemaVersionGate inspects the database's recorded schema version
+// before any migration is attempted, and refuses to auto-migrate when doing
+// so looks unsafe:
+//
+//   - the recorded version is dirty, meaning a previous migration failed
+//     partway through and the database needs manual recovery;
+//   - the recorded version is newer than anything this binary embeds,
+//     meaning the database was last touched by a newer lnd release;
+//   - the recorded version is 0 but the database already contains tables,
+//     which usually means the DSN points at the wrong, non-empty database
+//     (for example an lnd install that still has its bolt-migrated tables
+//     under a different name).
+func checkSchemaVersionGate(ctx context.Context, db *sql.DB, dbName string,
+	fsys fs.FS, migrationsDir, migrationsTable string) error {
+
+	version, dirty, err := currentSchemaVersion(ctx, db, migrationsTable)
+	if err != nil {
+		return fmt.Errorf("unable to read schema version: %w", err)
+	}
+
+	if dirty {
+		return fmt.Errorf("database %q has a dirty schema_migrations "+
+			"entry at version %d, refusing to auto-migrate; it "+
+			"needs manual recovery before lnd can start",
+			dbName, version)
+	}
+
+	if version == 0 {
+		nonEmpty, err := databaseHasTables(ctx, db, migrationsTable)
+		if err != nil {
+			return fmt.Errorf("unable to inspect database: %w", err)
+		}
+
+		if nonEmpty {
+			return fmt.Errorf("database %q is at schema version "+
+				"0 but already contains tables, refusing to "+
+				"auto-migrate a non-empty, unversioned "+
+				"database; double check this DSN points at "+
+				"the intended database", dbName)
+		}
+
+		return nil
+	}
+
+	latest, err := latestSchemaVersion(fsys, migrationsDir)
+	if err != nil {
+		return err
+	}
+
+	if version > latest {
+		return fmt.Errorf("database %q is at schema version %d, "+
+			"which is newer than the %d this binary knows about; "+
+			"refusing to auto-migrate, upgrade lnd before "+
+			"connecting to this database", dbName, version, latest)
+	}
+
+	return nil
+}
+
+// pendingSchemaMigrations returns the migration versions that are newer
+// than the database's current schema version and have not yet been
+// applied.
+func pendingSchemaMigrations(ctx context.Context, db *sql.DB, fsys fs.FS,
+	migrationsDir, migrationsTable string) ([]uint, error) {
+
+	current, _, err := currentSchemaVersion(ctx, db, migrationsTable)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read schema version: %w", err)
+	}
+
+	return pendingVersionsAbove(fsys, migrationsDir, current)
+}
+
+// pendingVersionsAbove returns the sorted, deduplicated set of migration
+// versions found in the migration source directory that are greater than
+// current. It does no I/O beyond reading the migration source directory, so
+// it's the part of pendingSchemaMigrations that's testable without a
+// database.
+func pendingVersionsAbove(fsys fs.FS, migrationsDir string,
+	current uint) ([]uint, error) {
+
+	entries, err := fs.ReadDir(fsys, migrationsDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read migrations dir: %w", err)
+	}
+
+	seen := make(map[uint]struct{})
+	var pending []uint
+	for _, entry := range entries {
+		matches := migrationVersionRE.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(matches[1], 10, 32)
+		if err != nil {
+			continue
+		}
+
+		v := uint(version)
+		if v <= current {
+			continue
+		}
+
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+
+		pending = append(pending, v)
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i] < pending[j]
+	})
+
+	return pending, nil
+}
@@ -0,0 +1,49 @@
+package sqldb
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func migrationsFixture() fstest.MapFS {
+	return fstest.MapFS{
+		"sqlc/migrations/000001_init.up.sql":       {Data: []byte("-- up")},
+		"sqlc/migrations/000001_init.down.sql":     {Data: []byte("-- down")},
+		"sqlc/migrations/000002_add_foo.up.sql":    {Data: []byte("-- up")},
+		"sqlc/migrations/000002_add_foo.down.sql":  {Data: []byte("-- down")},
+		"sqlc/migrations/000010_add_bar.up.sql":    {Data: []byte("-- up")},
+		"sqlc/migrations/000010_add_bar.down.sql":  {Data: []byte("-- down")},
+		"sqlc/migrations/not_a_migration_file.txt": {Data: []byte("ignored")},
+	}
+}
+
+func TestLatestSchemaVersion(t *testing.T) {
+	latest, err := latestSchemaVersion(migrationsFixture(), "sqlc/migrations")
+	require.NoError(t, err)
+	require.EqualValues(t, 10, latest)
+}
+
+func TestPendingVersionsAbove(t *testing.T) {
+	tests := []struct {
+		name    string
+		current uint
+		want    []uint
+	}{
+		{name: "nothing applied yet", current: 0, want: []uint{1, 2, 10}},
+		{name: "partially applied", current: 1, want: []uint{2, 10}},
+		{name: "fully applied", current: 10, want: nil},
+		{name: "newer than embedded", current: 99, want: nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pending, err := pendingVersionsAbove(
+				migrationsFixture(), "sqlc/migrations", tc.current,
+			)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, pending)
+		})
+	}
+}
@@ -8,11 +8,12 @@ import (
 	"fmt"
 	"net/url"
 	"path"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
 
-	postgres_migrate "github.com/golang-migrate/migrate/v4/database/postgres"
+	pgx_migrate "github.com/golang-migrate/migrate/v4/database/pgx/v5"
 	_ "github.com/golang-migrate/migrate/v4/source/file" // Read migrations from files. // nolint:lll
 	"github.com/lightningnetwork/lnd/sqldb/sqlc"
 	"github.com/stretchr/testify/require"
@@ -25,8 +26,55 @@ var (
 	// fully executed yet. So this time needs to be chosen correctly to be
 	// longer than the longest expected individual test run time.
 	DefaultPostgresFixtureLifetime = 10 * time.Minute
+
+	// postgresMigrationReplacements holds the SQL token substitutions
+	// that need to be applied to the shared sqlc schema files before
+	// they're run against Postgres.
+	postgresMigrationReplacements = map[string]string{
+		"BLOB":                "BYTEA",
+		"INTEGER PRIMARY KEY": "SERIAL PRIMARY KEY",
+		"BIGINT PRIMARY KEY":  "BIGSERIAL PRIMARY KEY",
+		"TIMESTAMP":           "TIMESTAMP WITHOUT TIME ZONE",
+	}
 )
 
+func init() {
+	// Postgres is the only backend this package registers today; SQLite,
+	// MySQL and others can register themselves the same way from their
+	// own init() without this package needing to know about them.
+	RegisterBackend(postgresBackend{}, "postgres", "postgresql", "pgx")
+}
+
+// postgresBackend implements the Backend interface, allowing sqldb.Open to
+// pick Postgres based on a DSN's URL scheme.
+type postgresBackend struct{}
+
+// Open creates a new Postgres-backed store using the default configuration
+// for the given DSN. It does not expose any of the tunables on
+// PostgresConfig (pool sizing, telemetry, a read-only replica, a custom
+// migrations table, CheckOnly, ...); callers that need those should
+// construct a PostgresConfig and call NewPostgresStore directly instead of
+// going through the registry.
+func (postgresBackend) Open(dsn string) (*BaseDB, error) {
+	store, err := NewPostgresStore(&PostgresConfig{Dsn: dsn})
+	if err != nil {
+		return nil, err
+	}
+
+	return store.BaseDB, nil
+}
+
+// Name returns the human-readable name of this backend.
+func (postgresBackend) Name() string {
+	return "postgres"
+}
+
+// MigrationReplacements returns the Postgres-specific schema token
+// substitutions.
+func (postgresBackend) MigrationReplacements() map[string]string {
+	return postgresMigrationReplacements
+}
+
 // PostgresConfig holds the postgres database configuration.
 //
 //nolint:lll
@@ -35,6 +83,62 @@ type PostgresConfig struct {
 	Timeout        time.Duration `long:"timeout" description:"Database connection timeout. Set to zero to disable."`
 	MaxConnections int           `long:"maxconnections" description:"The maximum number of open connections to the database. Set to zero for unlimited."`
 	SkipMigrations bool          `long:"skipmigrations" description:"Skip applying migrations on startup."`
+
+	// MaxIdleConnections is the maximum number of idle connections to
+	// keep open in the pool. Set to zero to use MaxConnections.
+	MaxIdleConnections int `long:"maxidleconnections" description:"The maximum number of idle connections to keep open. Set to zero to keep all MaxConnections open and idle."`
+
+	// ConnMaxLifetime is the maximum amount of time a connection may be
+	// reused for. Set to zero to use the default lifetime.
+	ConnMaxLifetime time.Duration `long:"connmaxlifetime" description:"The maximum amount of time a connection may be reused. Set to zero to use the default lifetime."`
+
+	// ConnMaxIdleTime is the maximum amount of time a connection may be
+	// idle before it is closed. Set to zero for no limit.
+	ConnMaxIdleTime time.Duration `long:"connmaxidletime" description:"The maximum amount of time a connection may be idle before being closed. Set to zero for no limit."`
+
+	// StatementTimeout bounds how long an individual migration statement
+	// is allowed to run for.
+	StatementTimeout time.Duration `long:"statementtimeout" description:"The maximum amount of time a single migration statement may run for. Set to zero to disable."`
+
+	// MigrationsTable is the name of the table used by golang-migrate to
+	// track the schema version.
+	MigrationsTable string `long:"migrationstable" description:"The name of the table used to track applied migrations."`
+
+	// MultiStatementEnabled allows a single migration file to contain
+	// multiple SQL statements separated by semicolons.
+	MultiStatementEnabled bool `long:"multistatementenabled" description:"Allow migration files to contain multiple semicolon-separated statements."`
+
+	// CheckOnly opens the database and validates its schema version
+	// without applying any pending migrations, regardless of
+	// SkipMigrations. Use SchemaVersion and PendingMigrations on the
+	// returned store to inspect what an upgrade would do before
+	// committing to it.
+	//
+	// NOTE: this only covers the sqldb-side plumbing. A "lncli sqldb
+	// migrate --dry-run" entry point that wires CheckOnly/PendingMigrations
+	// into an lncli subcommand does not exist in this tree (there is no
+	// lncli package here to add it to), so that part of the request is
+	// not satisfied.
+	CheckOnly bool `long:"checkonly" description:"Open the database and validate its schema version without applying any migrations."`
+
+	// Telemetry configures OpenTelemetry tracing and Prometheus pool
+	// metrics for this store.
+	//
+	// NOTE: this only instruments the Postgres primary and, if
+	// configured, read-replica pools built by NewPostgresStore. It does
+	// not wrap every path that can construct a BaseDB; there is no other
+	// backend in this tree to extend it to.
+	Telemetry TelemetryConfig `group:"telemetry" namespace:"telemetry" description:"Options controlling OpenTelemetry tracing and metrics for this database."`
+
+	// ReadOnlyDsn is an optional connection string for a read-only
+	// replica. When set, SELECT-style queries issued through the
+	// generated sqlc.Queries are routed to this database instead of the
+	// primary, while writes and migrations always stay on Dsn.
+	ReadOnlyDsn string `long:"readonlydsn" description:"Connection string for a read-only replica database. If left empty, all queries are served by the primary database."`
+
+	// ReadOnlyMaxConnections is the maximum number of open connections
+	// to the read-only replica. Set to zero to use the default limit.
+	ReadOnlyMaxConnections int `long:"readonlymaxconnections" description:"The maximum number of open connections to the read-only replica database. Set to zero to use the default limit."`
 }
 
 func (p *PostgresConfig) Validate() error {
@@ -48,9 +152,89 @@ func (p *PostgresConfig) Validate() error {
 		return fmt.Errorf("invalid DSN: %w", err)
 	}
 
+	if p.ReadOnlyDsn != "" {
+		_, err := url.Parse(p.ReadOnlyDsn)
+		if err != nil {
+			return fmt.Errorf("invalid read-only DSN: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// lockingReadRE matches a trailing row-locking clause on a SELECT
+// (FOR UPDATE, FOR SHARE, FOR NO KEY UPDATE, FOR KEY SHARE).
+var lockingReadRE = regexp.MustCompile(
+	`(?i)\bFOR\s+(UPDATE|SHARE|NO\s+KEY\s+UPDATE|KEY\s+SHARE)\b`,
+)
+
+// isReadQuery returns true if the given SQL statement is a read-only SELECT
+// query that is safe to route to a replica. A SELECT ... FOR UPDATE/SHARE
+// takes a row lock that is meaningless (or outright rejected) against a
+// read-only replica, so locking reads are treated like writes and kept on
+// the primary.
+func isReadQuery(query string) bool {
+	trimmed := strings.TrimSpace(query)
+
+	isSelect := len(trimmed) >= 6 &&
+		strings.EqualFold(trimmed[:6], "select")
+
+	return isSelect && !lockingReadRE.MatchString(trimmed)
+}
+
+// readReplicaRouter implements the sqlc.DBTX interface expected by the
+// generated queries, splitting queries between a primary and a read-only
+// replica pool. Any statement that isn't a plain SELECT is routed to the
+// primary, so writes (and anything running inside a transaction, which
+// never goes through this router since transactions are always opened
+// against the primary *sql.DB) are unaffected.
+type readReplicaRouter struct {
+	primary *sql.DB
+	replica *sql.DB
+}
+
+// newReadReplicaRouter creates a new router that dispatches read queries to
+// replica, falling back to primary if replica is nil.
+func newReadReplicaRouter(primary, replica *sql.DB) *readReplicaRouter {
+	return &readReplicaRouter{
+		primary: primary,
+		replica: replica,
+	}
+}
+
+// pool returns the database pool that should serve the given query.
+func (r *readReplicaRouter) pool(query string) *sql.DB {
+	if r.replica != nil && isReadQuery(query) {
+		return r.replica
+	}
+
+	return r.primary
+}
+
+func (r *readReplicaRouter) ExecContext(ctx context.Context, query string,
+	args ...interface{}) (sql.Result, error) {
+
+	return r.primary.ExecContext(ctx, query, args...)
+}
+
+func (r *readReplicaRouter) PrepareContext(ctx context.Context,
+	query string) (*sql.Stmt, error) {
+
+	return r.pool(query).PrepareContext(ctx, query)
+}
+
+func (r *readReplicaRouter) QueryContext(ctx context.Context, query string,
+	args ...interface{}) (*sql.Rows, error) {
+
+	return r.pool(query).QueryContext(ctx, query, args...)
+}
+
+func (r *readReplicaRouter) QueryRowContext(ctx context.Context, query string,
+	args ...interface{}) *sql.Row {
+
+	return r.pool(query).QueryRowContext(ctx, query, args...)
+}
+
 // replacePasswordInDSN takes a DSN string and returns it with the password
 // replaced by "***".
 func replacePasswordInDSN(dsn string) (string, error) {
@@ -97,9 +281,32 @@ func getDatabaseNameFromDSN(dsn string) (string, error) {
 type PostgresStore struct {
 	cfg *PostgresConfig
 
+	// readDB is the database pool used to serve read-only queries. It is
+	// the same as BaseDB.DB unless a read-only replica was configured.
+	readDB *sql.DB
+
+	// replicaDB is the read-only replica pool, or nil if none was
+	// configured. It is tracked separately from readDB so that Close can
+	// shut it down even though BaseDB only embeds the primary pool.
+	replicaDB *sql.DB
+
 	*BaseDB
 }
 
+// Close closes the primary database pool, along with the read-only replica
+// pool if one was configured.
+func (p *PostgresStore) Close() error {
+	err := p.BaseDB.DB.Close()
+
+	if p.replicaDB != nil {
+		if replicaErr := p.replicaDB.Close(); replicaErr != nil && err == nil {
+			err = replicaErr
+		}
+	}
+
+	return err
+}
+
 // NewPostgresStore creates a new store that is backed by a Postgres database
 // backend.
 func NewPostgresStore(cfg *PostgresConfig) (*PostgresStore, error) {
@@ -114,7 +321,14 @@ func NewPostgresStore(cfg *PostgresConfig) (*PostgresStore, error) {
 		return nil, err
 	}
 
-	rawDB, err := sql.Open("pgx", cfg.Dsn)
+	var rawDB *sql.DB
+	if cfg.Telemetry.Enabled {
+		rawDB, err = openTelemetryDB(
+			"pgx", cfg.Dsn, cfg.Telemetry, "primary",
+		)
+	} else {
+		rawDB, err = sql.Open("pgx", cfg.Dsn)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -124,31 +338,92 @@ func NewPostgresStore(cfg *PostgresConfig) (*PostgresStore, error) {
 		maxConns = cfg.MaxConnections
 	}
 
+	idleConns := maxConns
+	if cfg.MaxIdleConnections > 0 {
+		idleConns = cfg.MaxIdleConnections
+	}
+
+	lifetime := connIdleLifetime
+	if cfg.ConnMaxLifetime > 0 {
+		lifetime = cfg.ConnMaxLifetime
+	}
+
 	rawDB.SetMaxOpenConns(maxConns)
-	rawDB.SetMaxIdleConns(maxConns)
-	rawDB.SetConnMaxLifetime(connIdleLifetime)
+	rawDB.SetMaxIdleConns(idleConns)
+	rawDB.SetConnMaxLifetime(lifetime)
+	rawDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	// If a read-only replica was configured, open a second pool for it.
+	// Reads generated by sqlc.Queries will be routed to this pool, while
+	// writes and migrations always use rawDB above.
+	var replicaDB *sql.DB
+	if cfg.ReadOnlyDsn != "" {
+		if cfg.Telemetry.Enabled {
+			replicaDB, err = openTelemetryDB(
+				"pgx", cfg.ReadOnlyDsn, cfg.Telemetry, "replica",
+			)
+		} else {
+			replicaDB, err = sql.Open("pgx", cfg.ReadOnlyDsn)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		readMaxConns := defaultMaxConns
+		if cfg.ReadOnlyMaxConnections > 0 {
+			readMaxConns = cfg.ReadOnlyMaxConnections
+		}
+
+		replicaDB.SetMaxOpenConns(readMaxConns)
+		replicaDB.SetMaxIdleConns(readMaxConns)
+		replicaDB.SetConnMaxLifetime(lifetime)
+		replicaDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+
+	postgresFS := newReplacerFS(
+		sqlSchemas, postgresBackend{}.MigrationReplacements(),
+	)
+
+	migrationsTable := cfg.MigrationsTable
+	if migrationsTable == "" {
+		migrationsTable = pgx_migrate.DefaultMigrationsTable
+	}
 
-	if !cfg.SkipMigrations {
+	if !cfg.SkipMigrations || cfg.CheckOnly {
+		// Before we touch anything, make sure the database's current
+		// schema version is one we can safely migrate. This catches
+		// a database that's newer than this binary, or a non-empty
+		// database that was never versioned in the first place.
+		err = checkSchemaVersionGate(
+			context.Background(), rawDB, dbName, postgresFS,
+			"sqlc/migrations", migrationsTable,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !cfg.SkipMigrations && !cfg.CheckOnly {
 		// Now that the database is open, populate the database with
 		// our set of schemas based on our embedded in-memory file
 		// system.
 		//
 		// First, we'll need to open up a new migration instance for
-		// our current target database: Postgres.
-		driver, err := postgres_migrate.WithInstance(
-			rawDB, &postgres_migrate.Config{},
+		// our current target database: Postgres. We use the pgx/v5
+		// driver so that migrations run over the same pgx connection
+		// pool used for runtime queries, rather than through a
+		// second, incompatible driver.
+		driver, err := pgx_migrate.WithInstance(
+			rawDB, &pgx_migrate.Config{
+				MigrationsTable:       migrationsTable,
+				StatementTimeout:      cfg.StatementTimeout,
+				MultiStatementEnabled: cfg.MultiStatementEnabled,
+			},
 		)
 		if err != nil {
 			return nil, err
 		}
 
-		postgresFS := newReplacerFS(sqlSchemas, map[string]string{
-			"BLOB":                "BYTEA",
-			"INTEGER PRIMARY KEY": "SERIAL PRIMARY KEY",
-			"BIGINT PRIMARY KEY":  "BIGSERIAL PRIMARY KEY",
-			"TIMESTAMP":           "TIMESTAMP WITHOUT TIME ZONE",
-		})
-
 		err = applyMigrations(
 			postgresFS, driver, "sqlc/migrations", dbName,
 		)
@@ -157,10 +432,18 @@ func NewPostgresStore(cfg *PostgresConfig) (*PostgresStore, error) {
 		}
 	}
 
-	queries := sqlc.New(rawDB)
+	router := newReadReplicaRouter(rawDB, replicaDB)
+	queries := sqlc.New(router)
+
+	readDB := rawDB
+	if replicaDB != nil {
+		readDB = replicaDB
+	}
 
 	return &PostgresStore{
-		cfg: cfg,
+		cfg:       cfg,
+		readDB:    readDB,
+		replicaDB: replicaDB,
 		BaseDB: &BaseDB{
 			DB:      rawDB,
 			Queries: queries,
@@ -168,6 +451,57 @@ func NewPostgresStore(cfg *PostgresConfig) (*PostgresStore, error) {
 	}, nil
 }
 
+// ReadDB returns a BaseDB whose queries are always served by the read-only
+// replica, if one is configured, or by the primary database otherwise. This
+// lets callers that know they only need read access force that path
+// explicitly rather than relying on the per-query routing done for the
+// default Queries instance.
+//
+// The returned BaseDB embeds the replica's *sql.DB directly: calling
+// BeginTx or any write query on it sends that write to the replica, where
+// it will either be rejected (a true read-only replica) or, worse, silently
+// diverge from the primary. Only use ReadDB for read-only call paths.
+// Reads through it are also subject to the replica's replication lag, so
+// data read here may be momentarily behind what was just written on the
+// primary.
+func (p *PostgresStore) ReadDB() *BaseDB {
+	return &BaseDB{
+		DB:      p.readDB,
+		Queries: sqlc.New(p.readDB),
+	}
+}
+
+// migrationsTable returns the configured migrations table name, falling
+// back to golang-migrate's default when none was set.
+func (p *PostgresStore) migrationsTable() string {
+	if p.cfg.MigrationsTable != "" {
+		return p.cfg.MigrationsTable
+	}
+
+	return pgx_migrate.DefaultMigrationsTable
+}
+
+// SchemaVersion returns the schema version currently recorded in the
+// database, along with whether that version is marked dirty (i.e. a
+// previous migration failed partway through and needs manual recovery).
+func (p *PostgresStore) SchemaVersion(ctx context.Context) (uint, bool, error) {
+	return currentSchemaVersion(ctx, p.DB, p.migrationsTable())
+}
+
+// PendingMigrations returns the migration versions that are newer than the
+// database's current schema version and have not yet been applied. It can
+// be used to print a diff of what a migration run would do, without
+// actually applying anything (e.g. a "migrate --dry-run" style command).
+func (p *PostgresStore) PendingMigrations(ctx context.Context) ([]uint, error) {
+	postgresFS := newReplacerFS(
+		sqlSchemas, postgresBackend{}.MigrationReplacements(),
+	)
+
+	return pendingSchemaMigrations(
+		ctx, p.DB, postgresFS, "sqlc/migrations", p.migrationsTable(),
+	)
+}
+
 // NewTestPostgresDB is a helper function that creates a Postgres database for
 // testing using the given fixture.
 func NewTestPostgresDB(t *testing.T, fixture *TestPgFixture) *PostgresStore {
@@ -0,0 +1,114 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDriver/fakeConnector back a *sql.DB that's never actually dialed,
+// just used so readReplicaRouter has two distinct, comparable *sql.DB
+// values to route between.
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("fakeDriver: not implemented")
+}
+
+type fakeConnector struct{}
+
+func (fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return nil, errors.New("fakeConnector: not implemented")
+}
+
+func (fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+func newFakeDB() *sql.DB {
+	return sql.OpenDB(fakeConnector{})
+}
+
+func TestIsReadQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  bool
+	}{
+		{
+			name:  "plain select",
+			query: "SELECT * FROM foo",
+			want:  true,
+		},
+		{
+			name:  "lower case select",
+			query: "select 1",
+			want:  true,
+		},
+		{
+			name:  "leading whitespace",
+			query: "   SELECT 1",
+			want:  true,
+		},
+		{
+			name:  "insert",
+			query: "INSERT INTO foo VALUES (1)",
+			want:  false,
+		},
+		{
+			name:  "update",
+			query: "UPDATE foo SET a = 1",
+			want:  false,
+		},
+		{
+			name:  "select for update is a write",
+			query: "SELECT * FROM foo WHERE id = 1 FOR UPDATE",
+			want:  false,
+		},
+		{
+			name:  "select for share is a write",
+			query: "select * from foo for share",
+			want:  false,
+		},
+		{
+			name:  "select for no key update is a write",
+			query: "SELECT * FROM foo FOR NO KEY UPDATE",
+			want:  false,
+		},
+		{
+			name:  "empty query",
+			query: "",
+			want:  false,
+		},
+		{
+			name:  "too short to be select",
+			query: "SEL",
+			want:  false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, isReadQuery(tc.query))
+		})
+	}
+}
+
+func TestReadReplicaRouterPool(t *testing.T) {
+	primary := newFakeDB()
+	replica := newFakeDB()
+
+	withReplica := newReadReplicaRouter(primary, replica)
+	require.Same(t, replica, withReplica.pool("SELECT * FROM foo"))
+	require.Same(t, primary, withReplica.pool("INSERT INTO foo VALUES (1)"))
+	require.Same(
+		t, primary, withReplica.pool("SELECT * FROM foo FOR UPDATE"),
+	)
+
+	withoutReplica := newReadReplicaRouter(primary, nil)
+	require.Same(t, primary, withoutReplica.pool("SELECT * FROM foo"))
+}
@@ -0,0 +1,147 @@
+//go:build sqldb_telemetry
+
+package sqldb
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/XSAM/otelsql"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// dbReplicaRoleKey tags spans and query metrics with which pool served
+// them, "primary" or "replica", since a single telemetry-enabled store may
+// have both.
+const dbReplicaRoleKey = attribute.Key("db.replica_role")
+
+// TelemetryConfig controls OpenTelemetry tracing and Prometheus metrics for
+// a SQL backend.
+//
+//nolint:lll
+type TelemetryConfig struct {
+	// Enabled turns on query tracing and connection pool metrics for
+	// this store.
+	Enabled bool `long:"enabled" description:"Enable OpenTelemetry tracing and Prometheus metrics for SQL queries."`
+
+	// ServiceName is attached to every emitted span and to the exported
+	// pool metrics, so multiple stores can be told apart.
+	ServiceName string `long:"servicename" description:"The service name to attach to SQL spans and pool metrics."`
+}
+
+// openTelemetryDB opens an otelsql-instrumented database so every query run
+// through the resulting *sql.DB (including everything sqlc.Queries issues)
+// emits a span and duration/error metrics, and registers a Prometheus
+// collector exposing the pool's InUse, Idle, WaitCount and WaitDuration
+// stats. role identifies the pool as "primary" or "replica" so that a
+// telemetry-enabled store with a read replica exports two distinguishable
+// sets of metrics and spans instead of colliding.
+func openTelemetryDB(driverName, dsn string, cfg TelemetryConfig,
+	role string) (*sql.DB, error) {
+
+	attrs := otelsql.WithAttributes(
+		semconv.DBSystemPostgreSQL,
+		semconv.ServiceNameKey.String(cfg.ServiceName),
+		dbReplicaRoleKey.String(role),
+	)
+
+	db, err := otelsql.Open(driverName, dsn, attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	// otelsql.RegisterDBStatsMetrics returns an error when an instrument
+	// with the same attributes is already registered. That only means
+	// this role's pool stats won't be double-exported through the OTel
+	// meter; it's not a reason to fail opening the database, so it's
+	// ignored the same way the Prometheus collector's duplicate below is.
+	_ = otelsql.RegisterDBStatsMetrics(db, attrs)
+
+	err = prometheus.Register(newPoolStatsCollector(
+		db, cfg.ServiceName, role,
+	))
+	var alreadyRegistered prometheus.AlreadyRegisteredError
+	if err != nil && !errors.As(err, &alreadyRegistered) {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// poolStatsCollector is a prometheus.Collector that reports the live
+// connection pool stats of a *sql.DB. The service name and role are baked
+// in as const labels so that collectors for different stores, and for the
+// primary and replica pools of the same store, describe distinct metrics
+// and can be registered side by side in the same process.
+type poolStatsCollector struct {
+	db *sql.DB
+
+	inUse        *prometheus.Desc
+	idle         *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+}
+
+// newPoolStatsCollector creates a new collector for db's pool stats, tagged
+// with the given service name and role ("primary" or "replica").
+func newPoolStatsCollector(db *sql.DB, serviceName,
+	role string) *poolStatsCollector {
+
+	constLabels := prometheus.Labels{
+		"service": serviceName,
+		"role":    role,
+	}
+
+	return &poolStatsCollector{
+		db: db,
+		inUse: prometheus.NewDesc(
+			"sqldb_pool_in_use_connections",
+			"Number of connections currently in use.",
+			nil, constLabels,
+		),
+		idle: prometheus.NewDesc(
+			"sqldb_pool_idle_connections",
+			"Number of idle connections in the pool.",
+			nil, constLabels,
+		),
+		waitCount: prometheus.NewDesc(
+			"sqldb_pool_wait_count_total",
+			"Total number of connections waited for.",
+			nil, constLabels,
+		),
+		waitDuration: prometheus.NewDesc(
+			"sqldb_pool_wait_duration_seconds_total",
+			"Total time spent waiting for a connection.",
+			nil, constLabels,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *poolStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+}
+
+// Collect implements prometheus.Collector.
+func (c *poolStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.db.Stats()
+
+	ch <- prometheus.MustNewConstMetric(
+		c.inUse, prometheus.GaugeValue, float64(stats.InUse),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.idle, prometheus.GaugeValue, float64(stats.Idle),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.waitCount, prometheus.CounterValue, float64(stats.WaitCount),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.waitDuration, prometheus.CounterValue,
+		stats.WaitDuration.Seconds(),
+	)
+}
@@ -0,0 +1,37 @@
+//go:build !sqldb_telemetry
+
+package sqldb
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// TelemetryConfig controls OpenTelemetry tracing and Prometheus metrics for
+// a SQL backend. This build was compiled without the sqldb_telemetry tag,
+// so Enabled must stay false; the otelsql/OpenTelemetry dependencies are
+// only pulled in by a build tagged with sqldb_telemetry.
+//
+//nolint:lll
+type TelemetryConfig struct {
+	// Enabled turns on query tracing and connection pool metrics for
+	// this store. Setting this without building with -tags
+	// sqldb_telemetry is a configuration error.
+	Enabled bool `long:"enabled" description:"Enable OpenTelemetry tracing and Prometheus metrics for SQL queries. Requires a binary built with -tags sqldb_telemetry."`
+
+	// ServiceName is attached to every emitted span and to the exported
+	// pool metrics, so multiple stores can be told apart.
+	ServiceName string `long:"servicename" description:"The service name to attach to SQL spans and pool metrics."`
+}
+
+// openTelemetryDB always fails in this build: telemetry support isn't
+// compiled in, so there's no instrumented *sql.DB to return. Callers only
+// reach this when cfg.Enabled is true, and NewPostgresStore then returns
+// this error.
+func openTelemetryDB(driverName, dsn string, cfg TelemetryConfig,
+	role string) (*sql.DB, error) {
+
+	return nil, fmt.Errorf("telemetry support is not compiled into this "+
+		"binary; rebuild with -tags sqldb_telemetry to enable it "+
+		"(requested for %s role %q)", cfg.ServiceName, role)
+}